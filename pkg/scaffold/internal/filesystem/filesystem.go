@@ -0,0 +1,362 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+const (
+	// defaultDirectoryPermission is the directory mode New starts from, narrowed by the
+	// process umask, unless DirectoryPermissions is given.
+	// Scaffolded trees routinely contain sensitive material (kubeconfig snippets, webhook
+	// certs, controller manager configs), so it defaults to owner-only access.
+	defaultDirectoryPermission os.FileMode = 0700
+	// defaultFilePermission is the file mode New starts from, narrowed by the process
+	// umask, unless FilePermissions is given.
+	defaultFilePermission os.FileMode = 0600
+)
+
+// fileMode determines how fileSystem.Create behaves when the target file already exists.
+type fileMode int
+
+const (
+	// createOrUpdate creates the file if it does not exist yet and overwrites it otherwise.
+	createOrUpdate fileMode = iota
+)
+
+// FileSystem is a thin file-creation layer used by scaffolding so that callers don't need
+// to deal with directly creating directories/files and their permissions.
+type FileSystem interface {
+	// Exists returns true if path already exists.
+	Exists(path string) bool
+
+	// Open opens path for reading.
+	Open(path string) (afero.File, error)
+
+	// Create creates path, along with any missing parent directories, and returns a
+	// writer for its contents. By default the file is created with the FileSystem's
+	// configured file permission, which can be overridden per call with WithMode.
+	Create(path string, options ...CreateOption) (io.WriteCloser, error)
+
+	// Begin starts a transaction that stages writes and applies them atomically on Commit.
+	Begin() Txn
+
+	// InWritableDir runs fn with the parent directory of path guaranteed to be writable,
+	// per the AllowChmod option.
+	InWritableDir(path string, fn func() error) error
+
+	// Close releases any resources held by the FileSystem, e.g. a lock acquired via
+	// WithProjectLock.
+	Close() error
+}
+
+// Backend is the storage that a FileSystem reads from and writes to. Any afero.Fs
+// implementation can be plugged in as a Backend, which makes it possible to drive
+// scaffolding against something other than the real disk.
+type Backend = afero.Fs
+
+// NewInMemoryBackend returns a Backend that keeps every file in memory instead of writing
+// to disk. It is exported so that plugin authors can exercise FileSystem end-to-end (Exists,
+// Create, Write) from their own unit tests without touching the filesystem.
+func NewInMemoryBackend() Backend {
+	return afero.NewMemMapFs()
+}
+
+// NewOverlayBackend returns a Backend that layers an in-memory filesystem on top of the
+// real directory rooted at base. Reads fall through to base, while every write lands in
+// memory and base is never modified. It is meant for dry-run/preview modes that need to
+// render a whole scaffold for inspection before anything is committed to disk.
+func NewOverlayBackend(base string) Backend {
+	roBase := afero.NewReadOnlyFs(afero.NewBasePathFs(afero.NewOsFs(), base))
+	return afero.NewCopyOnWriteFs(roBase, afero.NewMemMapFs())
+}
+
+type fileSystem struct {
+	// fs is the backend that files are read from and written to.
+	fs afero.Fs
+
+	// dirPerm is the mode used when creating directories.
+	dirPerm os.FileMode
+	// filePerm is the mode used when creating files.
+	filePerm os.FileMode
+
+	// fileMode determines how Create behaves for files that already exist.
+	fileMode fileMode
+
+	// lock is held for the lifetime of the FileSystem when WithProjectLock is set.
+	lock Locker
+	// lockErr holds the error from acquiring lock. Every Create call returns it, as do
+	// Create, Commit and Rollback of any Txn returned by Begin.
+	lockErr error
+	// projectLockRoot is the project root passed to WithProjectLock, if any.
+	projectLockRoot string
+
+	// allowChmod lets Create temporarily relax a read-only parent directory's permissions.
+	allowChmod bool
+}
+
+// Option configures a FileSystem created by New.
+type Option func(*fileSystem)
+
+// DirectoryPermissions sets the mode used when creating directories.
+func DirectoryPermissions(perm os.FileMode) Option {
+	return func(fs *fileSystem) {
+		fs.dirPerm = perm
+	}
+}
+
+// FilePermissions sets the mode used when creating files.
+func FilePermissions(perm os.FileMode) Option {
+	return func(fs *fileSystem) {
+		fs.filePerm = perm
+	}
+}
+
+// WithBackend swaps the real-disk backend for an alternative one, e.g. a Backend returned
+// by NewInMemoryBackend or NewOverlayBackend.
+func WithBackend(backend Backend) Option {
+	return func(fs *fileSystem) {
+		fs.fs = backend
+	}
+}
+
+// WithProjectLock takes an advisory OS-level lock on a sentinel file under root before New
+// returns, preventing a concurrent kubebuilder invocation (or an IDE plugin and a CLI run)
+// from interleaving writes into the same project tree. The lock is released when the
+// FileSystem is closed. It is a no-op when combined with a non-disk Backend (e.g.
+// NewInMemoryBackend or NewOverlayBackend), since there is nothing on the real disk to
+// protect.
+func WithProjectLock(root string) Option {
+	return func(fs *fileSystem) {
+		fs.projectLockRoot = root
+	}
+}
+
+// New returns a new FileSystem. By default it reads from and writes to the real disk,
+// with default permissions narrowed by the process umask.
+func New(options ...Option) FileSystem {
+	umask := processUmask()
+
+	fs := fileSystem{
+		fs:       afero.NewOsFs(),
+		dirPerm:  defaultDirectoryPermission &^ umask,
+		filePerm: defaultFilePermission &^ umask,
+		fileMode: createOrUpdate,
+	}
+
+	for _, option := range options {
+		option(&fs)
+	}
+
+	if fs.projectLockRoot != "" {
+		// Only a real, on-disk backend has a project root worth protecting: in-memory and
+		// overlay backends used for tests/dry-run previews never touch it.
+		if _, ok := fs.fs.(*afero.OsFs); ok {
+			fs.lock = LockFile(filepath.Join(fs.projectLockRoot, lockFileName))
+			fs.lockErr = fs.lock.Lock()
+		}
+	}
+
+	return fs
+}
+
+func (fs fileSystem) Close() error {
+	if fs.lock == nil {
+		return nil
+	}
+	return fs.lock.Unlock()
+}
+
+func (fs fileSystem) Exists(path string) bool {
+	_, err := fs.fs.Stat(path)
+	return err == nil
+}
+
+func (fs fileSystem) Open(path string) (afero.File, error) {
+	return fs.fs.Open(path)
+}
+
+// CreateOption configures a single FileSystem.Create call.
+type CreateOption func(*createOptions)
+
+type createOptions struct {
+	mode *os.FileMode
+}
+
+// WithMode overrides the FileSystem's configured file permission for a single Create call,
+// e.g. to scaffold a file that is more (or less) sensitive than the rest of the project.
+func WithMode(mode os.FileMode) CreateOption {
+	return func(o *createOptions) {
+		o.mode = &mode
+	}
+}
+
+func (fs fileSystem) Create(path string, opts ...CreateOption) (io.WriteCloser, error) {
+	if fs.lockErr != nil {
+		return nil, fs.lockErr
+	}
+
+	options := createOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	perm := fs.filePerm
+	if options.mode != nil {
+		perm = *options.mode
+	}
+
+	dir := filepath.Dir(path)
+	if err := fs.fs.MkdirAll(dir, fs.dirPerm); err != nil {
+		return nil, createDirectoryError{dir, err}
+	}
+
+	var f afero.File
+	err := fs.InWritableDir(path, func() error {
+		var openErr error
+		f, openErr = fs.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+		return openErr
+	})
+	if err != nil {
+		if IsParentNotWritableError(err) {
+			return nil, err
+		}
+		return nil, createFileError{path, err}
+	}
+
+	return &file{File: f, path: path}, nil
+}
+
+// file wraps afero.File so that write/close failures are reported as the package's own
+// error types, consistent with Create.
+type file struct {
+	afero.File
+	path string
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	if err != nil {
+		return n, writeFileError{f.path, err}
+	}
+	return n, nil
+}
+
+func (f *file) Close() error {
+	if err := f.File.Sync(); err != nil {
+		return closeFileError{f.path, err}
+	}
+	if err := f.File.Close(); err != nil {
+		return closeFileError{f.path, err}
+	}
+	return nil
+}
+
+type createDirectoryError struct {
+	path string
+	err  error
+}
+
+func (e createDirectoryError) Error() string {
+	return fmt.Sprintf("failed to create directory %q: %v", e.path, e.err)
+}
+
+func (e createDirectoryError) Unwrap() error {
+	return e.err
+}
+
+// IsCreateDirectoryError checks if the provided error is a createDirectoryError.
+func IsCreateDirectoryError(err error) bool {
+	_, ok := err.(createDirectoryError)
+	return ok
+}
+
+type createFileError struct {
+	path string
+	err  error
+}
+
+func (e createFileError) Error() string {
+	return fmt.Sprintf("failed to create file %q: %v", e.path, e.err)
+}
+
+func (e createFileError) Unwrap() error {
+	return e.err
+}
+
+// IsCreateFileError checks if the provided error is a createFileError.
+func IsCreateFileError(err error) bool {
+	_, ok := err.(createFileError)
+	return ok
+}
+
+type writeFileError struct {
+	path string
+	err  error
+}
+
+func (e writeFileError) Error() string {
+	return fmt.Sprintf("failed to write file %q: %v", e.path, e.err)
+}
+
+func (e writeFileError) Unwrap() error {
+	return e.err
+}
+
+// IsWriteFileError checks if the provided error is a writeFileError.
+func IsWriteFileError(err error) bool {
+	_, ok := err.(writeFileError)
+	return ok
+}
+
+type closeFileError struct {
+	path string
+	err  error
+}
+
+func (e closeFileError) Error() string {
+	return fmt.Sprintf("failed to close file %q: %v", e.path, e.err)
+}
+
+func (e closeFileError) Unwrap() error {
+	return e.err
+}
+
+// IsCloseFileError checks if the provided error is a closeFileError.
+func IsCloseFileError(err error) bool {
+	_, ok := err.(closeFileError)
+	return ok
+}
+
+// IsPermissionError checks if the provided error, or any error it wraps, is an
+// os.IsPermission error, e.g. because a scaffold target directory has restrictive ACLs.
+func IsPermissionError(err error) bool {
+	for err != nil {
+		if os.IsPermission(err) {
+			return true
+		}
+		err = errors.Unwrap(err)
+	}
+	return false
+}
@@ -24,6 +24,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/spf13/afero"
 )
 
 func TestFileSystem(t *testing.T) {
@@ -215,6 +216,51 @@ var _ = Describe("FileSystem", func() {
 			})
 		})
 
+		Context("IsParentNotWritableError", func() {
+			It("should return true for parent-not-writable errors", func() {
+				Expect(IsParentNotWritableError(parentNotWritableError{path, err})).To(BeTrue())
+			})
+
+			It("should return false for any other error", func() {
+				Expect(IsParentNotWritableError(err)).To(BeFalse())
+				Expect(IsParentNotWritableError(createDirectoryErr)).To(BeFalse())
+			})
+		})
+
+		Context("IsLockAcquireError", func() {
+			It("should return true for lock acquire errors", func() {
+				Expect(IsLockAcquireError(lockAcquireError{path, err})).To(BeTrue())
+			})
+
+			It("should return false for any other error", func() {
+				Expect(IsLockAcquireError(err)).To(BeFalse())
+				Expect(IsLockAcquireError(createDirectoryErr)).To(BeFalse())
+			})
+		})
+
+		Context("IsCommitError", func() {
+			It("should return true for commit errors", func() {
+				Expect(IsCommitError(commitError{path, err})).To(BeTrue())
+			})
+
+			It("should return false for any other error", func() {
+				Expect(IsCommitError(err)).To(BeFalse())
+				Expect(IsCommitError(createDirectoryErr)).To(BeFalse())
+			})
+		})
+
+		Context("IsPermissionError", func() {
+			It("should return true for wrapped permission errors", func() {
+				permErr := createFileError{path, os.ErrPermission}
+				Expect(IsPermissionError(permErr)).To(BeTrue())
+			})
+
+			It("should return false for any other error", func() {
+				Expect(IsPermissionError(err)).To(BeFalse())
+				Expect(IsPermissionError(createDirectoryErr)).To(BeFalse())
+			})
+		})
+
 		Describe("error messages", func() {
 			It("should contain the wrapped err", func() {
 				Expect(createDirectoryErr.Error()).To(ContainSubstring(err.Error()))
@@ -225,6 +271,299 @@ var _ = Describe("FileSystem", func() {
 		})
 	})
 
-	// NOTE: FileSystem.Exists, FileSystem.Create and FileSystem.Create().Write are hard
-	// to test in unitary tests as they deal with actual files
+	Describe("backends", func() {
+		Context("when using the in-memory backend", func() {
+			var fsi FileSystem
+
+			BeforeEach(func() {
+				fsi = New(WithBackend(NewInMemoryBackend()))
+			})
+
+			It("should report a path as missing until it is created", func() {
+				Expect(fsi.Exists("file.txt")).To(BeFalse())
+
+				f, err := fsi.Create("file.txt")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				Expect(fsi.Exists("file.txt")).To(BeTrue())
+			})
+
+			It("should create missing parent directories", func() {
+				f, err := fsi.Create(filepath.Join("nested", "dir", "file.txt"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				Expect(fsi.Exists(filepath.Join("nested", "dir", "file.txt"))).To(BeTrue())
+			})
+
+			It("should write the provided content", func() {
+				f, err := fsi.Create("file.txt")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = f.Write([]byte("hello"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				r, err := fsi.Open("file.txt")
+				Expect(err).NotTo(HaveOccurred())
+				defer r.Close()
+
+				content, err := afero.ReadAll(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("hello"))
+			})
+
+			It("should use the mode passed to WithMode instead of the default", func() {
+				f, err := fsi.Create("file.txt", WithMode(0600))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				info, err := fsi.(fileSystem).fs.Stat("file.txt")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			})
+		})
+
+		Context("when using the overlay backend", func() {
+			It("should not write through to the underlying directory", func() {
+				dir, err := afero.TempDir(afero.NewOsFs(), "", "filesystem-overlay")
+				Expect(err).NotTo(HaveOccurred())
+				defer os.RemoveAll(dir)
+
+				fsi := New(WithBackend(NewOverlayBackend(dir)))
+
+				f, err := fsi.Create("file.txt")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				Expect(fsi.Exists("file.txt")).To(BeTrue())
+				_, err = os.Stat(filepath.Join(dir, "file.txt"))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("Txn", func() {
+		var fsi FileSystem
+
+		BeforeEach(func() {
+			fsi = New(WithBackend(NewInMemoryBackend()))
+		})
+
+		Context("when every staged file commits", func() {
+			It("should apply all of them", func() {
+				txn := fsi.Begin()
+
+				f1, err := txn.Create("a.txt")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = f1.Write([]byte("a"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f1.Close()).To(Succeed())
+
+				f2, err := txn.Create(filepath.Join("nested", "b.txt"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f2.Close()).To(Succeed())
+
+				Expect(fsi.Exists("a.txt")).To(BeFalse())
+
+				Expect(txn.Commit()).To(Succeed())
+
+				Expect(fsi.Exists("a.txt")).To(BeTrue())
+				Expect(fsi.Exists(filepath.Join("nested", "b.txt"))).To(BeTrue())
+			})
+		})
+
+		Context("when Rollback is called instead of Commit", func() {
+			It("should leave the filesystem untouched", func() {
+				txn := fsi.Begin()
+
+				f, err := txn.Create("a.txt")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				Expect(txn.Rollback()).To(Succeed())
+
+				Expect(fsi.Exists("a.txt")).To(BeFalse())
+			})
+		})
+
+		Context("when Commit fails partway through", func() {
+			It("should restore the pre-existing content of any file it already committed", func() {
+				f, err := fsi.Create("conflict.txt")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = f.Write([]byte("original"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				txn := fsi.Begin()
+
+				f1, err := txn.Create("conflict.txt")
+				Expect(err).NotTo(HaveOccurred())
+				_, err = f1.Write([]byte("updated"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f1.Close()).To(Succeed())
+
+				f2, err := txn.Create("second.txt")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f2.Close()).To(Succeed())
+
+				// Simulate the second file's staged tempfile disappearing out from under
+				// the transaction, so its rename into place fails after conflict.txt has
+				// already been committed.
+				Expect(fsi.(fileSystem).fs.Remove("second.txt.kubebuilder-tmp-1")).To(Succeed())
+
+				err = txn.Commit()
+				Expect(IsCommitError(err)).To(BeTrue())
+
+				r, err := fsi.Open("conflict.txt")
+				Expect(err).NotTo(HaveOccurred())
+				defer r.Close()
+
+				content, err := afero.ReadAll(r)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal("original"))
+			})
+		})
+
+		Context("when a staged file's target directory is read-only", func() {
+			It("should return a parentNotWritableError without AllowChmod", func() {
+				Expect(fsi.(fileSystem).fs.MkdirAll("readonly", 0500)).To(Succeed())
+
+				txn := fsi.Begin()
+				f, err := txn.Create(filepath.Join("readonly", "file.txt"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				err = txn.Commit()
+				Expect(IsParentNotWritableError(err)).To(BeTrue())
+			})
+
+			It("should succeed and restore permissions with AllowChmod", func() {
+				fsi = New(WithBackend(NewInMemoryBackend()), AllowChmod(true))
+				Expect(fsi.(fileSystem).fs.MkdirAll("readonly", 0500)).To(Succeed())
+
+				txn := fsi.Begin()
+				f, err := txn.Create(filepath.Join("readonly", "file.txt"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				Expect(txn.Commit()).To(Succeed())
+				Expect(fsi.Exists(filepath.Join("readonly", "file.txt"))).To(BeTrue())
+
+				info, err := fsi.(fileSystem).fs.Stat("readonly")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0500)))
+			})
+		})
+
+		Context("when the project lock failed to acquire", func() {
+			It("should return the lock error from Commit even without a prior Create", func() {
+				lockErr := errors.New("boom")
+				txn := fileSystem{lockErr: lockErr}.Begin()
+
+				Expect(txn.Commit()).To(Equal(lockErr))
+			})
+
+			It("should return the lock error from Rollback even without a prior Create", func() {
+				lockErr := errors.New("boom")
+				txn := fileSystem{lockErr: lockErr}.Begin()
+
+				Expect(txn.Rollback()).To(Equal(lockErr))
+			})
+		})
+	})
+
+	Describe("InWritableDir", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = afero.TempDir(afero.NewOsFs(), "", "filesystem-writable")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(os.Chmod(dir, 0500)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.Chmod(dir, 0700)).To(Succeed())
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		Context("when the parent directory is not writable and AllowChmod is unset", func() {
+			It("should return a parentNotWritableError", func() {
+				fsi := New()
+
+				_, err := fsi.Create(filepath.Join(dir, "file.txt"))
+				Expect(IsParentNotWritableError(err)).To(BeTrue())
+			})
+		})
+
+		Context("when the parent directory is not writable and AllowChmod is set", func() {
+			It("should write the file and restore the original permissions", func() {
+				fsi := New(AllowChmod(true))
+
+				f, err := fsi.Create(filepath.Join(dir, "file.txt"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+
+				info, err := os.Stat(dir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode().Perm()).To(Equal(os.FileMode(0500)))
+			})
+		})
+
+		Context("when the owner-write bit is set but the directory belongs to another user", func() {
+			It("should not be treated as writable", func() {
+				if os.Geteuid() != 0 {
+					Skip("requires root to chown the directory to a different uid")
+				}
+
+				Expect(os.Chmod(dir, 0755)).To(Succeed())
+				Expect(os.Chown(dir, os.Geteuid()+1, os.Getegid()+1)).To(Succeed())
+				defer os.Chown(dir, os.Geteuid(), os.Getegid())
+
+				fsi := New()
+
+				_, err := fsi.Create(filepath.Join(dir, "file.txt"))
+				Expect(IsParentNotWritableError(err)).To(BeTrue())
+			})
+		})
+	})
+
+	Describe("WithProjectLock", func() {
+		It("should prevent a second lock on the same project until the FileSystem is closed", func() {
+			dir, err := afero.TempDir(afero.NewOsFs(), "", "filesystem-lock")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			first := New(WithProjectLock(dir))
+			defer first.Close()
+
+			locked, err := LockFile(filepath.Join(dir, lockFileName)).TryLock()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locked).To(BeFalse())
+
+			Expect(first.Close()).To(Succeed())
+
+			locked, err = LockFile(filepath.Join(dir, lockFileName)).TryLock()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(locked).To(BeTrue())
+		})
+
+		It("should be a no-op when combined with a non-disk backend", func() {
+			dir, err := afero.TempDir(afero.NewOsFs(), "", "filesystem-lock")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			fsi := New(WithBackend(NewInMemoryBackend()), WithProjectLock(dir))
+			defer fsi.Close()
+
+			f, err := fsi.Create("file.txt")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(f.Close()).To(Succeed())
+
+			_, err = os.Stat(filepath.Join(dir, lockFileName))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
 })
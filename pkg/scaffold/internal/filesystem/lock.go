@@ -0,0 +1,107 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// lockFileName is the sentinel file locked at the project root when WithProjectLock is
+// set, so that two concurrent kubebuilder invocations (or an IDE plugin and a CLI run)
+// can't interleave writes into the same project tree.
+const lockFileName = ".kubebuilder-lock"
+
+// Locker takes an advisory, OS-level lock (flock on unix, LockFileEx on windows) on a
+// single file.
+type Locker interface {
+	// Lock blocks until the lock is acquired.
+	Lock() error
+
+	// TryLock attempts to acquire the lock without blocking, returning false if it is
+	// already held elsewhere.
+	TryLock() (bool, error)
+
+	// TryLockTimeout attempts to acquire the lock, giving up once timeout elapses. It is
+	// meant for CI scenarios where blocking indefinitely isn't acceptable.
+	TryLockTimeout(timeout time.Duration) (bool, error)
+
+	// Unlock releases the lock.
+	Unlock() error
+}
+
+// LockFile returns a Locker for path, which is created if it does not already exist.
+func LockFile(path string) Locker {
+	return &fileLock{path: path, fl: flock.New(path)}
+}
+
+type fileLock struct {
+	path string
+	fl   *flock.Flock
+}
+
+func (l *fileLock) Lock() error {
+	if err := l.fl.Lock(); err != nil {
+		return lockAcquireError{l.path, err}
+	}
+	return nil
+}
+
+func (l *fileLock) TryLock() (bool, error) {
+	ok, err := l.fl.TryLock()
+	if err != nil {
+		return false, lockAcquireError{l.path, err}
+	}
+	return ok, nil
+}
+
+func (l *fileLock) TryLockTimeout(timeout time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	ok, err := l.fl.TryLockContext(ctx, 50*time.Millisecond)
+	if err != nil {
+		return false, lockAcquireError{l.path, err}
+	}
+	return ok, nil
+}
+
+func (l *fileLock) Unlock() error {
+	return l.fl.Unlock()
+}
+
+type lockAcquireError struct {
+	path string
+	err  error
+}
+
+func (e lockAcquireError) Error() string {
+	return fmt.Sprintf("failed to acquire lock on %q: %v", e.path, e.err)
+}
+
+func (e lockAcquireError) Unwrap() error {
+	return e.err
+}
+
+// IsLockAcquireError checks if the provided error is a lockAcquireError.
+func IsLockAcquireError(err error) bool {
+	_, ok := err.(lockAcquireError)
+	return ok
+}
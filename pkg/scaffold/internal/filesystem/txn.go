@@ -0,0 +1,230 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Txn stages a set of writes so that they can be applied to the FileSystem as a single
+// all-or-nothing operation. Use it when a scaffolder writes multiple related files and a
+// failure partway through (e.g. `kubebuilder create api` erroring out after the types file
+// but before the controller) must not leave a half-written project on disk.
+type Txn interface {
+	// Create stages path for writing and takes the same options as FileSystem.Create. The
+	// staged file is written next to path without going through InWritableDir, so on a
+	// backend that enforces permissions, staging into a read-only parent fails with a
+	// createFileError even when AllowChmod is set. The returned writer must be closed
+	// before Commit or Rollback is called.
+	Create(path string, options ...CreateOption) (io.WriteCloser, error)
+
+	// Commit renames every staged file into place. Either all of them land, or (best
+	// effort) none of them do, and any file that existed at a target path before the
+	// transaction started is restored rather than lost.
+	Commit() error
+
+	// Rollback removes every staged tempfile and leaves every target path untouched.
+	// Directories created while staging are not removed.
+	Rollback() error
+}
+
+// stagedFile tracks where a pending write currently lives and where it belongs once the
+// transaction commits. staged is a sibling tempfile next to target (same directory) so
+// that Commit's rename lands on the same filesystem/mount and never crosses an EXDEV
+// boundary.
+type stagedFile struct {
+	target string
+	staged string
+}
+
+// appliedFile tracks a staged file that has already been renamed into place during Commit,
+// so a later failure can undo it.
+type appliedFile struct {
+	target    string
+	backup    string
+	hadBackup bool
+}
+
+type txn struct {
+	fs     fileSystem
+	staged []stagedFile
+	done   bool
+}
+
+// Begin starts a transaction that stages writes next to their eventual targets and applies
+// them atomically on Commit.
+func (fs fileSystem) Begin() Txn {
+	if fs.lockErr != nil {
+		return &txn{fs: fs, done: true}
+	}
+	return &txn{fs: fs}
+}
+
+func (t *txn) Create(path string, opts ...CreateOption) (io.WriteCloser, error) {
+	if t.fs.lockErr != nil {
+		return nil, t.fs.lockErr
+	}
+	if t.done {
+		return nil, createFileError{path, fmt.Errorf("transaction is already closed")}
+	}
+
+	options := createOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	perm := t.fs.filePerm
+	if options.mode != nil {
+		perm = *options.mode
+	}
+
+	dir := filepath.Dir(path)
+	if err := t.fs.fs.MkdirAll(dir, t.fs.dirPerm); err != nil {
+		return nil, createDirectoryError{dir, err}
+	}
+
+	stagedPath := fmt.Sprintf("%s.kubebuilder-tmp-%d", path, len(t.staged))
+	f, err := t.fs.fs.OpenFile(stagedPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return nil, createFileError{path, err}
+	}
+
+	t.staged = append(t.staged, stagedFile{target: path, staged: stagedPath})
+
+	return &file{File: f, path: path}, nil
+}
+
+// Commit renames each staged file into place in the order Create was called. If a rename
+// fails partway through, the renames that already succeeded are best-effort undone,
+// restoring any target's pre-existing content, so a failed Commit does not leave a
+// partially-applied scaffold behind.
+func (t *txn) Commit() error {
+	if t.fs.lockErr != nil {
+		return t.fs.lockErr
+	}
+	if t.done {
+		return nil
+	}
+	t.done = true
+	defer t.cleanupStaged()
+
+	applied := make([]appliedFile, 0, len(t.staged))
+	for _, sf := range t.staged {
+		af, err := t.commitOne(sf)
+		if err != nil {
+			t.undo(applied)
+			return err
+		}
+		applied = append(applied, af)
+	}
+
+	for _, af := range applied {
+		if af.hadBackup {
+			_ = t.fs.fs.Remove(af.backup)
+		}
+	}
+
+	return nil
+}
+
+// commitOne moves any pre-existing file at sf.target aside before renaming sf.staged into
+// place. Only the rename into place runs through InWritableDir, so a read-only target
+// directory yields a parentNotWritableError, or is relaxed for the rename with AllowChmod;
+// moving the pre-existing file aside happens before that and is not relaxed.
+func (t *txn) commitOne(sf stagedFile) (appliedFile, error) {
+	backupPath := sf.target + ".kubebuilder-bak"
+	hadBackup := t.fs.Exists(sf.target)
+
+	if hadBackup {
+		if err := t.fs.fs.Rename(sf.target, backupPath); err != nil {
+			return appliedFile{}, commitError{sf.target, err}
+		}
+	}
+
+	err := t.fs.InWritableDir(sf.target, func() error {
+		if err := t.fs.fs.MkdirAll(filepath.Dir(sf.target), t.fs.dirPerm); err != nil {
+			return err
+		}
+		return t.fs.fs.Rename(sf.staged, sf.target)
+	})
+	if err != nil {
+		if hadBackup {
+			_ = t.fs.fs.Rename(backupPath, sf.target)
+		}
+		if IsParentNotWritableError(err) {
+			return appliedFile{}, err
+		}
+		return appliedFile{}, commitError{sf.target, err}
+	}
+
+	return appliedFile{target: sf.target, backup: backupPath, hadBackup: hadBackup}, nil
+}
+
+// undo reverts already-applied renames in reverse order: the new content is removed and
+// any pre-existing content that was moved aside in commitOne is restored.
+func (t *txn) undo(applied []appliedFile) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		af := applied[i]
+		_ = t.fs.fs.Remove(af.target)
+		if af.hadBackup {
+			_ = t.fs.fs.Rename(af.backup, af.target)
+		}
+	}
+}
+
+// cleanupStaged removes the sibling tempfiles left behind by Create. Entries that were
+// already renamed into place by Commit no longer exist at their staged path, so removing
+// them is a harmless no-op.
+func (t *txn) cleanupStaged() {
+	for _, sf := range t.staged {
+		_ = t.fs.fs.Remove(sf.staged)
+	}
+}
+
+func (t *txn) Rollback() error {
+	if t.fs.lockErr != nil {
+		return t.fs.lockErr
+	}
+	if t.done {
+		return nil
+	}
+	t.done = true
+	t.cleanupStaged()
+	return nil
+}
+
+type commitError struct {
+	path string
+	err  error
+}
+
+func (e commitError) Error() string {
+	return fmt.Sprintf("failed to commit file %q: %v", e.path, e.err)
+}
+
+func (e commitError) Unwrap() error {
+	return e.err
+}
+
+// IsCommitError checks if the provided error is a commitError.
+func IsCommitError(err error) bool {
+	_, ok := err.(commitError)
+	return ok
+}
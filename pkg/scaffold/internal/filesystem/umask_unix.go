@@ -0,0 +1,68 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//go:build !windows
+
+package filesystem
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processUmask returns the umask of the current process. On Linux it is read directly from
+// /proc/self/status, which exposes it without touching any process-global state. Elsewhere
+// (darwin, bsd, ...) there is no such readable interface, so it falls back to the
+// set-then-restore syscall.Umask trick, which narrows but cannot fully close the window
+// where a concurrent goroutine creating a file would see umask 0.
+func processUmask() os.FileMode {
+	if mask, ok := umaskFromProcStatus(); ok {
+		return mask
+	}
+	return umaskFromSyscall()
+}
+
+func umaskFromProcStatus() (os.FileMode, bool) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "Umask:" {
+			continue
+		}
+
+		mask, err := strconv.ParseUint(fields[1], 8, 32)
+		if err != nil {
+			return 0, false
+		}
+		return os.FileMode(mask), true
+	}
+	return 0, false
+}
+
+func umaskFromSyscall() os.FileMode {
+	mask := syscall.Umask(0)
+	syscall.Umask(mask)
+	return os.FileMode(mask)
+}
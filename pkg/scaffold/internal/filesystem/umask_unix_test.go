@@ -0,0 +1,42 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//go:build !windows
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("New", func() {
+	Context("when the process umask clears owner bits", func() {
+		It("should narrow the default directory and file permissions accordingly", func() {
+			old := syscall.Umask(0700)
+			defer syscall.Umask(old)
+
+			fs, ok := New().(fileSystem)
+			Expect(ok).To(BeTrue())
+
+			Expect(fs.dirPerm).To(Equal(os.FileMode(0)))
+			Expect(fs.filePerm).To(Equal(os.FileMode(0)))
+		})
+	})
+})
@@ -0,0 +1,96 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// AllowChmod lets Create temporarily relax a read-only parent directory's permissions so
+// that it can write into trees the process doesn't own, e.g. a vendored config/ subdir
+// checked out read-only by CI. Without it, writing into a read-only parent fails with a
+// parentNotWritableError instead.
+func AllowChmod(allow bool) Option {
+	return func(fs *fileSystem) {
+		fs.allowChmod = allow
+	}
+}
+
+// InWritableDir runs fn with the parent directory of path guaranteed to be writable by the
+// current process. If the parent is not writable and AllowChmod was not set, it returns a
+// parentNotWritableError without calling fn. If AllowChmod was set, the parent's mode is
+// temporarily relaxed for the duration of fn and always restored afterward, even if fn
+// fails, so a failed write never leaves permissions loosened behind it.
+func (fs fileSystem) InWritableDir(path string, fn func() error) error {
+	dir := filepath.Dir(path)
+
+	info, err := fs.fs.Stat(dir)
+	if err != nil {
+		return fn()
+	}
+
+	original := info.Mode()
+	if isWritable(info) {
+		return fn()
+	}
+
+	if !fs.allowChmod {
+		return parentNotWritableError{dir, fmt.Errorf("directory is not writable by the current process")}
+	}
+
+	if err := fs.fs.Chmod(dir, original|0200); err != nil {
+		return parentNotWritableError{dir, err}
+	}
+	defer fs.fs.Chmod(dir, original)
+
+	return fn()
+}
+
+// isWritable reports whether the current process can write to a directory with the given
+// stat info. Reading the mode bits alone isn't enough: an owner-writable 0755 directory
+// isn't actually writable by a process running as a different uid. A set other-write bit
+// (0002) makes the directory writable for everyone; otherwise isOwnerOrGroupWritable
+// resolves the owner (0200) and group (0020) write bits against the stat'd owner/group
+// where the platform exposes that information.
+func isWritable(info os.FileInfo) bool {
+	perm := info.Mode().Perm()
+	if perm&0002 != 0 {
+		return true
+	}
+	return isOwnerOrGroupWritable(info, perm)
+}
+
+type parentNotWritableError struct {
+	path string
+	err  error
+}
+
+func (e parentNotWritableError) Error() string {
+	return fmt.Sprintf("parent directory %q is not writable: %v", e.path, e.err)
+}
+
+func (e parentNotWritableError) Unwrap() error {
+	return e.err
+}
+
+// IsParentNotWritableError checks if the provided error is a parentNotWritableError.
+func IsParentNotWritableError(err error) bool {
+	_, ok := err.(parentNotWritableError)
+	return ok
+}
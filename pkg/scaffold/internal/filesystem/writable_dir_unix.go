@@ -0,0 +1,45 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//go:build !windows
+
+package filesystem
+
+import (
+	"os"
+	"syscall"
+)
+
+// isOwnerOrGroupWritable resolves perm's owner/group write bits against the directory's
+// actual uid/gid, so a directory owned by another user (e.g. config/ checked out by CI as
+// a different uid) isn't mistaken for writable just because its owner bit happens to be set.
+func isOwnerOrGroupWritable(info os.FileInfo, perm os.FileMode) bool {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		// No uid/gid info available (e.g. an in-memory backend) — there's no real
+		// ownership to compare against, so fall back to the owner bit.
+		return perm&0200 != 0
+	}
+
+	switch {
+	case int(stat.Uid) == os.Geteuid():
+		return perm&0200 != 0
+	case int(stat.Gid) == os.Getegid():
+		return perm&0020 != 0
+	default:
+		return false
+	}
+}
@@ -0,0 +1,27 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+//go:build windows
+
+package filesystem
+
+import "os"
+
+// isOwnerOrGroupWritable falls back to the owner bit on Windows, where os.FileInfo.Mode
+// doesn't reflect the real ACL-based ownership/write checks.
+func isOwnerOrGroupWritable(info os.FileInfo, perm os.FileMode) bool {
+	return perm&0200 != 0
+}